@@ -13,14 +13,28 @@ import (
 	"github.com/Azure/aad-pod-identity/test/e2e/azureidentity"
 	"github.com/Azure/aad-pod-identity/test/e2e/util"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
 )
 
-// List is a container that holds all deployment returned from 'kubectl get deploy'
+// List is a container that holds all deployments returned by the API server
 type List struct {
 	Deploys []Deploy `json:"items"`
 }
 
 // Deploy is used to parse data from 'kubectl get deploy'
+//
+// Deprecated: use appsv1.Deployment via Client instead.
 type Deploy struct {
 	Metadata Metadata `json:"metadata"`
 	Spec     Spec     `json:"spec"`
@@ -42,7 +56,423 @@ type Status struct {
 	AvailableReplicas int `json:"availableReplicas"`
 }
 
-// Create will create a demo deployment on a Kubernetes cluster
+// Client wraps a Kubernetes clientset and drives Deployment operations
+// against the API server directly instead of shelling out to kubectl.
+type Client struct {
+	kubeClient kubernetes.Interface
+	namespace  string
+}
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultPollInterval = 3 * time.Second
+)
+
+// DefaultTimeout is how long WaitOnReady will wait for a deployment to
+// become ready by default. It is sourced from E2E_DEPLOY_TIMEOUT at startup,
+// falling back to defaultTimeout. Create's WithWait option always uses the
+// timeout passed to it.
+var DefaultTimeout = envDurationOrDefault("E2E_DEPLOY_TIMEOUT", defaultTimeout)
+
+// DefaultPollInterval is how long WaitOnReady waits by default before
+// reopening its watch after a failed Watch() call or a watch.Error event.
+// It is sourced from E2E_DEPLOY_POLL at startup, falling back to
+// defaultPollInterval.
+var DefaultPollInterval = envDurationOrDefault("E2E_DEPLOY_POLL", defaultPollInterval)
+
+func envDurationOrDefault(env string, def time.Duration) time.Duration {
+	v := os.Getenv(env)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid duration %q for %s, using default %s: %s", v, env, def, err)
+		return def
+	}
+	return d
+}
+
+// waitConfig holds the options accepted by WaitOnReady.
+type waitConfig struct {
+	timeout      time.Duration
+	pollInterval time.Duration
+	ctx          context.Context
+}
+
+// WaitOption configures WaitOnReady.
+type WaitOption func(*waitConfig)
+
+// WithTimeout overrides how long WaitOnReady will wait before giving up.
+func WithTimeout(timeout time.Duration) WaitOption {
+	return func(c *waitConfig) { c.timeout = timeout }
+}
+
+// WithPollInterval overrides how long WaitOnReady waits before reopening its
+// watch after a failed Watch() call or a watch.Error event.
+func WithPollInterval(interval time.Duration) WaitOption {
+	return func(c *waitConfig) { c.pollInterval = interval }
+}
+
+// WithContext overrides the parent context WaitOnReady waits against.
+func WithContext(ctx context.Context) WaitOption {
+	return func(c *waitConfig) { c.ctx = ctx }
+}
+
+// createConfig holds the options accepted by Create.
+type createConfig struct {
+	wait    bool
+	timeout time.Duration
+}
+
+// CreateOption configures Create.
+type CreateOption func(*createConfig)
+
+// WithWait makes Create block until the deployment it created is ready,
+// failing with a timeout error if it does not become so within timeout.
+func WithWait(timeout time.Duration) CreateOption {
+	return func(c *createConfig) {
+		c.wait = true
+		c.timeout = timeout
+	}
+}
+
+// NewClient builds a Client from the kubeconfig pointed to by the
+// KUBECONFIG environment variable, falling back to in-cluster config
+// when it is not set.
+func NewClient(namespace string) (*Client, error) {
+	var (
+		config *rest.Config
+		err    error
+	)
+
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build kubeconfig")
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create kubernetes client")
+	}
+
+	return &Client{kubeClient: kubeClient, namespace: namespace}, nil
+}
+
+// Create will create a demo deployment on a Kubernetes cluster. By default
+// it returns as soon as the create/update call succeeds; pass WithWait to
+// block until the deployment's rollout is complete.
+func (c *Client) Create(ctx context.Context, subscriptionID, resourceGroup, name, identityBinding, templateOutputPath string, opts ...CreateOption) (*appsv1.Deployment, error) {
+	cfg := &createConfig{timeout: DefaultTimeout}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	clientID, err := azureidentity.GetClientID(resourceGroup, identityBinding)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := template.New("deployment.yaml").ParseFiles(path.Join("template", "deployment.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	deployFilePath := path.Join(templateOutputPath, name+"-deployment.yaml")
+	deployFile, err := os.Create(deployFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer deployFile.Close()
+
+	deployData := struct {
+		SubscriptionID  string
+		ResourceGroup   string
+		ClientID        string
+		Name            string
+		IdentityBinding string
+	}{
+		subscriptionID,
+		resourceGroup,
+		clientID,
+		name,
+		identityBinding,
+	}
+	if err := t.Execute(deployFile, deployData); err != nil {
+		return nil, err
+	}
+
+	var deployment appsv1.Deployment
+	raw, err := os.ReadFile(deployFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(raw, &deployment); err != nil {
+		return nil, errors.Wrap(err, "failed to decode rendered deployment manifest")
+	}
+
+	existing, err := c.kubeClient.AppsV1().Deployments(c.namespace).Get(ctx, name, metav1.GetOptions{})
+	var result *appsv1.Deployment
+	switch {
+	case apierrors.IsNotFound(err):
+		result, err = c.kubeClient.AppsV1().Deployments(c.namespace).Create(ctx, &deployment, metav1.CreateOptions{})
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to get existing deployment")
+	default:
+		deployment.ResourceVersion = existing.ResourceVersion
+		result, err = c.kubeClient.AppsV1().Deployments(c.namespace).Update(ctx, &deployment, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.wait {
+		if _, err := c.WaitOnReady(ctx, name, WithTimeout(cfg.timeout)); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Delete will delete a deployment on a Kubernetes cluster
+func (c *Client) Delete(ctx context.Context, name string) error {
+	err := c.kubeClient.AppsV1().Deployments(c.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// GetAll will return all the deployments in the client's namespace
+func (c *Client) GetAll(ctx context.Context) (*appsv1.DeploymentList, error) {
+	return c.kubeClient.AppsV1().Deployments(c.namespace).List(ctx, metav1.ListOptions{})
+}
+
+// Get will return a single deployment by name
+func (c *Client) Get(ctx context.Context, name string) (*appsv1.Deployment, error) {
+	return c.GetIn(ctx, c.namespace, name)
+}
+
+// GetIn will return a single deployment by namespace and name, regardless of
+// the namespace the Client was constructed with.
+func (c *Client) GetIn(ctx context.Context, ns, name string) (*appsv1.Deployment, error) {
+	return c.kubeClient.AppsV1().Deployments(ns).Get(ctx, name, metav1.GetOptions{})
+}
+
+// List will return every deployment in ns matching selector.
+func (c *Client) List(ctx context.Context, ns string, selector labels.Selector) (*appsv1.DeploymentList, error) {
+	return c.kubeClient.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+}
+
+// WaitOnReadySelector blocks until every deployment in ns matching selector
+// has a complete rollout, waiting on them concurrently and aggregating the
+// first error encountered. It lets a caller wait on, e.g., the whole
+// MIC+NMI+demo-app set with one call instead of chaining individual
+// WaitOnReady calls per deployment name.
+func (c *Client) WaitOnReadySelector(ctx context.Context, ns string, selector labels.Selector, opts ...WaitOption) error {
+	dl, err := c.List(ctx, ns, selector)
+	if err != nil {
+		return err
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	for i := range dl.Items {
+		name := dl.Items[i].Name
+		g.Go(func() error {
+			_, err := c.waitOnReadyIn(ctx, ns, name, opts...)
+			return err
+		})
+	}
+	return g.Wait()
+}
+
+// IsAvailableReplicasMatchDesired will return a boolean that indicates whether the number
+// of available replicas of a deployment matches the desired number of replicas
+func (c *Client) IsAvailableReplicasMatchDesired(ctx context.Context, name string) (bool, error) {
+	d, err := c.Get(ctx, name)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	return d.Status.AvailableReplicas == desired, nil
+}
+
+// IsRolloutComplete reports whether a deployment's rollout has fully
+// completed, mirroring the notion of "done" used by
+// k8s.io/kubernetes/pkg/controller/deployment/util.DeploymentComplete: the
+// controller has observed the latest spec, every replica has been updated,
+// no old replicas remain, and all of them are available. If the deployment's
+// Progressing condition reports ProgressDeadlineExceeded, it returns an
+// error immediately rather than waiting for the caller's timeout to expire.
+func (c *Client) IsRolloutComplete(ctx context.Context, name string) (bool, error) {
+	return c.isRolloutCompleteIn(ctx, c.namespace, name)
+}
+
+func (c *Client) isRolloutCompleteIn(ctx context.Context, ns, name string) (bool, error) {
+	d, err := c.GetIn(ctx, ns, name)
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isDeploymentRolloutComplete(d)
+}
+
+func isDeploymentRolloutComplete(d *appsv1.Deployment) (bool, error) {
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse && cond.Reason == "ProgressDeadlineExceeded" {
+			return false, errors.Errorf("deployment %q exceeded its progress deadline: %s", d.Name, cond.Message)
+		}
+	}
+
+	if d.Status.ObservedGeneration < d.Generation {
+		return false, nil
+	}
+
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+
+	if d.Status.UpdatedReplicas != desired {
+		return false, nil
+	}
+	if d.Status.Replicas != desired {
+		return false, nil
+	}
+	if d.Status.AvailableReplicas != desired {
+		return false, nil
+	}
+	return true, nil
+}
+
+// WaitOnReady will block until a deployment's rollout is complete, or until
+// the timeout elapses. It defaults to DefaultTimeout/DefaultPollInterval,
+// both overridable via WithTimeout/WithPollInterval, or via the
+// E2E_DEPLOY_TIMEOUT/E2E_DEPLOY_POLL environment variables at startup.
+//
+// Rather than polling, it watches the deployment and reacts to the first
+// event that satisfies the rollout-complete predicate. Opening the watch is
+// retried on an interval of its own (WithPollInterval) so a WaitOnReady
+// issued just before the deployment exists doesn't fail outright.
+func (c *Client) WaitOnReady(ctx context.Context, name string, opts ...WaitOption) (bool, error) {
+	return c.waitOnReadyIn(ctx, c.namespace, name, opts...)
+}
+
+func (c *Client) waitOnReadyIn(ctx context.Context, ns, name string, opts ...WaitOption) (bool, error) {
+	cfg := &waitConfig{
+		timeout:      DefaultTimeout,
+		pollInterval: DefaultPollInterval,
+		ctx:          ctx,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	ctx, cancel := context.WithTimeout(cfg.ctx, cfg.timeout)
+	defer cancel()
+
+	return c.watchUntilRolloutComplete(ctx, ns, name, cfg.pollInterval)
+}
+
+// watchUntilRolloutComplete opens a watch scoped to name and blocks until an
+// event reports the rollout complete, the deployment is deleted, or ctx is
+// done. If Watch itself fails (e.g. the deployment doesn't exist yet) or the
+// watch reports a watch.Error event, it is reattempted every retryInterval
+// until ctx is done; if the watch channel simply closes before a terminal
+// event arrives, the watch is reopened at once.
+func (c *Client) watchUntilRolloutComplete(ctx context.Context, ns, name string, retryInterval time.Duration) (bool, error) {
+	for {
+		watcher, err := c.kubeClient.AppsV1().Deployments(ns).Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
+		})
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return false, errors.Wrapf(err, "timeout exceeded while opening watch for deployment %q", name)
+			case <-time.After(retryInterval):
+				continue
+			}
+		}
+
+		ready, reopen, backoff, err := consumeRolloutEvents(ctx, watcher)
+		watcher.Stop()
+		if err != nil {
+			return false, err
+		}
+		if ready {
+			return true, nil
+		}
+		if !reopen {
+			return false, errors.Errorf("timeout exceeded while waiting for deployment %q to be ready", name)
+		}
+		if backoff {
+			select {
+			case <-ctx.Done():
+				return false, errors.Errorf("timeout exceeded while waiting for deployment %q to be ready", name)
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// consumeRolloutEvents drains watcher until either a Deployment event
+// satisfies isDeploymentRolloutComplete (ready=true), the deployment is
+// deleted (err set), the watch channel closes (reopen=true, meaning the
+// caller should reattempt the watch), or ctx finishes (all results false and
+// err nil, meaning the caller should give up). A watch.Error event sets
+// backoff=true so the caller waits out retryInterval before reopening,
+// the same backoff a failed Watch() call gets, instead of hot-looping
+// against an API server that is erroring mid-stream.
+func consumeRolloutEvents(ctx context.Context, watcher watch.Interface) (ready bool, reopen bool, backoff bool, err error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, false, false, nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, true, false, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return false, false, false, errors.Errorf("deployment was deleted while waiting for it to become ready")
+			case watch.Error:
+				return false, true, true, nil
+			}
+
+			d, ok := event.Object.(*appsv1.Deployment)
+			if !ok {
+				continue
+			}
+			complete, err := isDeploymentRolloutComplete(d)
+			if err != nil {
+				return false, false, false, err
+			}
+			if complete {
+				return true, false, false, nil
+			}
+		}
+	}
+}
+
+// Create will create a demo deployment on a Kubernetes cluster.
+//
+// Deprecated: use (*Client).Create, which talks to the API server directly
+// instead of shelling out to kubectl.
 func Create(subscriptionID, resourceGroup, name, identityBinding, templateOutputPath string) error {
 	clientID, err := azureidentity.GetClientID(resourceGroup, identityBinding)
 	if err != nil {
@@ -88,7 +518,10 @@ func Create(subscriptionID, resourceGroup, name, identityBinding, templateOutput
 	return nil
 }
 
-// Delete will delete a deployment on a Kubernetes cluster
+// Delete will delete a deployment on a Kubernetes cluster.
+//
+// Deprecated: use (*Client).Delete, which talks to the API server directly
+// instead of shelling out to kubectl.
 func Delete(name, templateOutputPath string) error {
 	cmd := exec.Command("kubectl", "delete", "-f", path.Join(templateOutputPath, name+"-deployment.yaml"), "--ignore-not-found")
 	util.PrintCommand(cmd)
@@ -96,7 +529,10 @@ func Delete(name, templateOutputPath string) error {
 	return err
 }
 
-// GetAll will return a list of deployment on a Kubernetes cluster
+// GetAll will return a list of deployment on a Kubernetes cluster.
+//
+// Deprecated: use (*Client).GetAll, which talks to the API server directly
+// instead of shelling out to kubectl.
 func GetAll() (*List, error) {
 	cmd := exec.Command("kubectl", "get", "deploy", "-ojson")
 	util.PrintCommand(cmd)
@@ -113,7 +549,10 @@ func GetAll() (*List, error) {
 }
 
 // IsAvailableReplicasMatchDesired will return a boolean that indicate whether the number
-// of available replicas of a deployment matches the desired number of replicas
+// of available replicas of a deployment matches the desired number of replicas.
+//
+// Deprecated: use (*Client).IsAvailableReplicasMatchDesired, which talks to the
+// API server directly instead of shelling out to kubectl.
 func IsAvailableReplicasMatchDesired(name string) (bool, error) {
 	dl, err := GetAll()
 	if err != nil {
@@ -129,7 +568,11 @@ func IsAvailableReplicasMatchDesired(name string) (bool, error) {
 	return false, nil
 }
 
-// WaitOnReady will block until the number of replicas of a deployment is equal to the specified amount
+// WaitOnReady will block until the number of available replicas of a deployment is equal to its
+// desired number of replicas, or fail once 30 seconds have passed.
+//
+// Deprecated: use (*Client).WaitOnReady, which talks to the API server directly
+// instead of polling kubectl.
 func WaitOnReady(name string) (bool, error) {
 	successChannel, errorChannel := make(chan bool, 1), make(chan error)
 	duration := 30 * time.Second
@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func TestIsDeploymentRolloutComplete(t *testing.T) {
+	cases := []struct {
+		name      string
+		deploy    *appsv1.Deployment
+		wantReady bool
+		wantErr   bool
+	}{
+		{
+			name: "observed generation lags spec generation",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+				},
+			},
+			wantReady: false,
+		},
+		{
+			name: "updated replicas have not caught up",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+					UpdatedReplicas:    2,
+					Replicas:           3,
+					AvailableReplicas:  3,
+				},
+			},
+			wantReady: false,
+		},
+		{
+			name: "old replicas still around",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+					UpdatedReplicas:    3,
+					Replicas:           4,
+					AvailableReplicas:  3,
+				},
+			},
+			wantReady: false,
+		},
+		{
+			name: "replicas not yet available",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+					UpdatedReplicas:    3,
+					Replicas:           3,
+					AvailableReplicas:  2,
+				},
+			},
+			wantReady: false,
+		},
+		{
+			name: "rollout complete",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+					UpdatedReplicas:    3,
+					Replicas:           3,
+					AvailableReplicas:  3,
+				},
+			},
+			wantReady: true,
+		},
+		{
+			name: "defaults to one replica when spec.replicas is nil",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+					UpdatedReplicas:    1,
+					Replicas:           1,
+					AvailableReplicas:  1,
+				},
+			},
+			wantReady: true,
+		},
+		{
+			name: "progress deadline exceeded fails fast",
+			deploy: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Generation: 1},
+				Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+				Status: appsv1.DeploymentStatus{
+					ObservedGeneration: 1,
+					Conditions: []appsv1.DeploymentCondition{
+						{
+							Type:   appsv1.DeploymentProgressing,
+							Status: corev1.ConditionFalse,
+							Reason: "ProgressDeadlineExceeded",
+						},
+					},
+				},
+			},
+			wantReady: false,
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ready, err := isDeploymentRolloutComplete(tc.deploy)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("isDeploymentRolloutComplete() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if ready != tc.wantReady {
+				t.Errorf("isDeploymentRolloutComplete() = %v, want %v", ready, tc.wantReady)
+			}
+		})
+	}
+}
+
+func TestEnvDurationOrDefault(t *testing.T) {
+	const env = "E2E_DEPLOY_TEST_DURATION"
+	def := 7 * time.Second
+
+	cases := []struct {
+		name string
+		val  string
+		want time.Duration
+	}{
+		{name: "unset uses default", val: "", want: def},
+		{name: "valid duration is parsed", val: "45s", want: 45 * time.Second},
+		{name: "invalid duration falls back to default", val: "not-a-duration", want: def},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(env, tc.val)
+
+			if got := envDurationOrDefault(env, def); got != tc.want {
+				t.Errorf("envDurationOrDefault(%q) = %v, want %v", tc.val, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func notReadyDeployment(name string, partOf string) *appsv1.Deployment {
+	d := readyDeployment(name)
+	d.Labels = map[string]string{"app.kubernetes.io/part-of": partOf}
+	d.Status.AvailableReplicas = 0
+	return d
+}
+
+func TestWaitOnReadySelectorWaitsForEveryMatch(t *testing.T) {
+	kubeClient := fakeclientset.NewSimpleClientset(
+		notReadyDeployment("mic", "aad-pod-identity"),
+		notReadyDeployment("nmi", "aad-pod-identity"),
+		notReadyDeployment("unrelated", "other-app"),
+	)
+	c := &Client{kubeClient: kubeClient, namespace: "default"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = kubeClient.AppsV1().Deployments("default").Update(context.Background(), readyDeployment("mic"), metav1.UpdateOptions{})
+		_, _ = kubeClient.AppsV1().Deployments("default").Update(context.Background(), readyDeployment("nmi"), metav1.UpdateOptions{})
+	}()
+
+	selector := labels.SelectorFromSet(labels.Set{"app.kubernetes.io/part-of": "aad-pod-identity"})
+	if err := c.WaitOnReadySelector(ctx, "default", selector, WithPollInterval(50*time.Millisecond)); err != nil {
+		t.Fatalf("WaitOnReadySelector() error = %v", err)
+	}
+}
+
+func TestWaitOnReadySelectorAggregatesErrors(t *testing.T) {
+	kubeClient := fakeclientset.NewSimpleClientset(
+		notReadyDeployment("mic", "aad-pod-identity"),
+	)
+	c := &Client{kubeClient: kubeClient, namespace: "default"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = kubeClient.AppsV1().Deployments("default").Delete(context.Background(), "mic", metav1.DeleteOptions{})
+	}()
+
+	selector := labels.SelectorFromSet(labels.Set{"app.kubernetes.io/part-of": "aad-pod-identity"})
+	if err := c.WaitOnReadySelector(ctx, "default", selector, WithPollInterval(50*time.Millisecond)); err == nil {
+		t.Fatal("WaitOnReadySelector() error = nil, want an error from the deleted deployment")
+	}
+}
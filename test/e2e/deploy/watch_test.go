@@ -0,0 +1,124 @@
+package deploy
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func readyDeployment(name string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(1)},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 1,
+			UpdatedReplicas:    1,
+			Replicas:           1,
+			AvailableReplicas:  1,
+		},
+	}
+}
+
+func TestConsumeRolloutEvents(t *testing.T) {
+	t.Run("ready on a Modified event that satisfies the rollout predicate", func(t *testing.T) {
+		fw := watch.NewFake()
+		go fw.Modify(readyDeployment("demo"))
+
+		ready, reopen, backoff, err := consumeRolloutEvents(context.Background(), fw)
+		if err != nil || !ready || reopen || backoff {
+			t.Fatalf("got (ready=%v, reopen=%v, backoff=%v, err=%v), want (true, false, false, nil)", ready, reopen, backoff, err)
+		}
+	})
+
+	t.Run("deleted deployment is a terminal error", func(t *testing.T) {
+		fw := watch.NewFake()
+		go fw.Delete(readyDeployment("demo"))
+
+		ready, reopen, backoff, err := consumeRolloutEvents(context.Background(), fw)
+		if err == nil || ready || reopen || backoff {
+			t.Fatalf("got (ready=%v, reopen=%v, backoff=%v, err=%v), want (false, false, false, non-nil)", ready, reopen, backoff, err)
+		}
+	})
+
+	t.Run("watch.Error event requests a backoff before reopening", func(t *testing.T) {
+		fw := watch.NewFake()
+		go fw.Error(&metav1.Status{Message: "etcdserver: too many requests"})
+
+		ready, reopen, backoff, err := consumeRolloutEvents(context.Background(), fw)
+		if err != nil || ready || !reopen || !backoff {
+			t.Fatalf("got (ready=%v, reopen=%v, backoff=%v, err=%v), want (false, true, true, nil)", ready, reopen, backoff, err)
+		}
+	})
+
+	t.Run("closed channel reopens immediately with no backoff", func(t *testing.T) {
+		fw := watch.NewFake()
+		fw.Stop()
+
+		ready, reopen, backoff, err := consumeRolloutEvents(context.Background(), fw)
+		if err != nil || ready || !reopen || backoff {
+			t.Fatalf("got (ready=%v, reopen=%v, backoff=%v, err=%v), want (false, true, false, nil)", ready, reopen, backoff, err)
+		}
+	})
+
+	t.Run("context cancellation gives up without reopening", func(t *testing.T) {
+		fw := watch.NewFake()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		ready, reopen, backoff, err := consumeRolloutEvents(ctx, fw)
+		if err != nil || ready || reopen || backoff {
+			t.Fatalf("got (ready=%v, reopen=%v, backoff=%v, err=%v), want (false, false, false, nil)", ready, reopen, backoff, err)
+		}
+	})
+}
+
+// TestWatchUntilRolloutCompleteReopensAfterFailedWatch drives the outer retry
+// loop end to end: the first Watch() call fails outright, forcing a
+// retryInterval backoff, after which the reopened watch observes the
+// deployment becoming ready.
+func TestWatchUntilRolloutCompleteReopensAfterFailedWatch(t *testing.T) {
+	kubeClient := fakeclientset.NewSimpleClientset()
+
+	var watchCalls int32
+	kubeClient.PrependWatchReactor("deployments", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		if atomic.AddInt32(&watchCalls, 1) == 1 {
+			return true, nil, errors.New("simulated transient API error")
+		}
+		// Let the real watch register before the deployment shows up, the
+		// same way a rollout that starts just after WaitOnReady is called
+		// would.
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = kubeClient.AppsV1().Deployments("default").Create(context.Background(), readyDeployment("demo"), metav1.CreateOptions{})
+		}()
+		return false, nil, nil
+	})
+
+	c := &Client{kubeClient: kubeClient, namespace: "default"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	ready, err := c.watchUntilRolloutComplete(ctx, "default", "demo", 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("watchUntilRolloutComplete() error = %v", err)
+	}
+	if !ready {
+		t.Fatalf("watchUntilRolloutComplete() = %v, want true", ready)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("expected at least one retryInterval backoff after the failed Watch() call, took %s", elapsed)
+	}
+	if calls := atomic.LoadInt32(&watchCalls); calls < 2 {
+		t.Fatalf("expected Watch() to be reattempted after failing, got %d call(s)", calls)
+	}
+}